@@ -0,0 +1,124 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	securityv1beta1 "istio.io/api/security/v1beta1"
+	"istio.io/istio/pkg/config/labels"
+)
+
+// Service is the subset of a Kubernetes Service's attributes the RBAC
+// builder needs to resolve the v1alpha1 ServiceRole/ServiceRoleBinding
+// policies and ServiceMetadata for a sidecar-proxied workload.
+type Service struct {
+	Hostname   Hostname
+	Attributes ServiceAttributes
+}
+
+// Hostname is the fully qualified domain name Envoy uses to address a
+// Service, e.g. "reviews.default.svc.cluster.local".
+type Hostname string
+
+// ServiceAttributes are the name/namespace a Service is registered under.
+type ServiceAttributes struct {
+	Name      string
+	Namespace string
+}
+
+// ServiceInstance pairs a Service with the specific instance (pod) a proxy
+// is fronting, which is what NewBuilder is handed for a sidecar workload.
+type ServiceInstance struct {
+	Service *Service
+}
+
+// AuthorizationPolicy pins a single matched v1beta1 AuthorizationPolicy to
+// the Kubernetes UID/ResourceVersion it was read from, so callers such as
+// the RBAC cache can key on exactly which policy revisions fed into a
+// generated config.
+type AuthorizationPolicy struct {
+	UID             string
+	ResourceVersion string
+	Namespace       string
+	Name            string
+	Spec            *securityv1beta1.AuthorizationPolicy
+}
+
+// AuthorizationPolicies is the snapshot of every authorization-related
+// config object Pilot has observed: v1alpha1 ServiceRole/ServiceRoleBinding,
+// v1beta1 AuthorizationPolicy, and (see authorization_json.go) the JSON
+// authorization policy source.
+type AuthorizationPolicies struct {
+	// rbacEnabled and globalPermissiveEnabled drive the legacy v1alpha1
+	// ServiceRole/ServiceRoleBinding path; rbacEnabled is indexed by
+	// "namespace/hostname" the same way the v1alpha1 RbacConfig scopes
+	// enablement.
+	rbacEnabled             map[string]bool
+	globalPermissiveEnabled bool
+
+	// byNamespace indexes every known v1beta1 AuthorizationPolicy by the
+	// namespace it was created in, for ListAuthorizationPolicies to scan.
+	byNamespace map[string][]AuthorizationPolicy
+
+	// jsonPolicies indexes the JSON authorization policy sources (see
+	// authorization_json.go); nil if none have been observed.
+	jsonPolicies *jsonAuthorizationIndex
+}
+
+// NewAuthorizationPolicies creates an empty AuthorizationPolicies snapshot.
+func NewAuthorizationPolicies() *AuthorizationPolicies {
+	return &AuthorizationPolicies{
+		rbacEnabled: map[string]bool{},
+		byNamespace: map[string][]AuthorizationPolicy{},
+		jsonPolicies: &jsonAuthorizationIndex{
+			byNamespace:            map[string][]AuthorizationPolicyJSON{},
+			annotationsByNamespace: map[string]string{},
+		},
+	}
+}
+
+// IsGlobalPermissiveEnabled reports whether the mesh-wide RBAC permissive
+// mode (dry-run, only log would-be-denied requests) is enabled.
+func (policies *AuthorizationPolicies) IsGlobalPermissiveEnabled() bool {
+	if policies == nil {
+		return false
+	}
+	return policies.globalPermissiveEnabled
+}
+
+// IsRBACEnabled reports whether the legacy v1alpha1 RBAC policy applies to
+// the given Service hostname in namespace.
+func (policies *AuthorizationPolicies) IsRBACEnabled(hostname, namespace string) bool {
+	if policies == nil {
+		return false
+	}
+	return policies.rbacEnabled[namespace+"/"+hostname]
+}
+
+// ListAuthorizationPolicies returns every v1beta1 AuthorizationPolicy in
+// namespace whose selector matches workloadLabels, or that has no selector
+// and so applies to every workload in that namespace.
+func (policies *AuthorizationPolicies) ListAuthorizationPolicies(namespace string, workloadLabels labels.Collection) []AuthorizationPolicy {
+	if policies == nil {
+		return nil
+	}
+	var matched []AuthorizationPolicy
+	for _, p := range policies.byNamespace[namespace] {
+		selector := p.Spec.GetSelector().GetMatchLabels()
+		if len(selector) == 0 || workloadLabels.IsSupersetOf(selector) {
+			matched = append(matched, p)
+		}
+	}
+	return matched
+}
@@ -0,0 +1,71 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import "istio.io/istio/pkg/config/labels"
+
+// jsonPolicyAnnotation is the workload/service annotation an operator can
+// attach a raw JSON authorization policy document to (see
+// pilot/pkg/security/authz/policy/jsonpolicy for its schema), as a
+// lighter-weight alternative to creating an AuthorizationPolicyJSON
+// resource.
+const jsonPolicyAnnotation = "authorization.istio.io/json-policy"
+
+// AuthorizationPolicyJSON is the CRD that attaches a raw JSON authorization
+// policy document to every workload in a namespace whose labels match
+// Selector.
+type AuthorizationPolicyJSON struct {
+	Namespace string
+	Name      string
+
+	// Selector scopes this policy to matching workloads, the same
+	// matchLabels semantics AuthorizationPolicy.Spec.Selector uses. A nil
+	// Selector applies to every workload in Namespace.
+	Selector map[string]string
+
+	// JSON is the raw JSON authorization policy document.
+	JSON string
+}
+
+// jsonAuthorizationIndex indexes AuthorizationPolicyJSON resources
+// (byNamespace) and the annotation form (annotationsByNamespace), both by
+// namespace. AuthorizationPolicies holds it alongside the v1beta1 index, so
+// a single snapshot swap keeps every authorization source consistent.
+type jsonAuthorizationIndex struct {
+	byNamespace            map[string][]AuthorizationPolicyJSON
+	annotationsByNamespace map[string]string
+}
+
+// JSONAuthorizationPolicyFor returns the raw JSON authorization policy
+// document that applies to a workload in namespace with workloadLabels, if
+// any. An AuthorizationPolicyJSON resource whose selector matches
+// workloadLabels takes precedence over the annotation form; at most one JSON
+// policy document applies to a given workload. The annotation form is
+// looked up by namespace only: the document recorded for namespace applies
+// to every workload in it, whatever its labels.
+func (policies *AuthorizationPolicies) JSONAuthorizationPolicyFor(namespace string, workloadLabels labels.Collection) (string, bool) {
+	if policies == nil || policies.jsonPolicies == nil {
+		return "", false
+	}
+	for _, p := range policies.jsonPolicies.byNamespace[namespace] {
+		if len(p.Selector) == 0 || workloadLabels.IsSupersetOf(p.Selector) {
+			return p.JSON, true
+		}
+	}
+	if raw, ok := policies.jsonPolicies.annotationsByNamespace[namespace]; ok {
+		return raw, true
+	}
+	return "", false
+}
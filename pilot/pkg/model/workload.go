@@ -0,0 +1,52 @@
+// Copyright 2023 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import "istio.io/istio/pkg/config/labels"
+
+// WorkloadInfo is the subset of an ambient-mode workload's attributes
+// NewWorkloadBuilder needs. Unlike ServiceInstance, it carries no Service:
+// ambient workloads are addressed by pod identity, not by a Kubernetes
+// Service.
+type WorkloadInfo struct {
+	Namespace      string
+	Name           string
+	ServiceAccount string
+	Labels         labels.Collection
+}
+
+// WorkloadAuthorization is a v1beta1 AuthorizationPolicy that
+// ListWorkloadAuthorizations matched for an ambient-mode workload. It carries
+// nothing beyond the embedded policy.
+type WorkloadAuthorization struct {
+	AuthorizationPolicy
+}
+
+// ListWorkloadAuthorizations returns every v1beta1 AuthorizationPolicy that
+// applies to an ambient-mode workload in namespace with workloadLabels, the
+// same selector-matching semantics as ListAuthorizationPolicies.
+func (policies *AuthorizationPolicies) ListWorkloadAuthorizations(namespace string, workloadLabels labels.Collection) []WorkloadAuthorization {
+	if policies == nil {
+		return nil
+	}
+	var matched []WorkloadAuthorization
+	for _, p := range policies.byNamespace[namespace] {
+		selector := p.Spec.GetSelector().GetMatchLabels()
+		if len(selector) == 0 || workloadLabels.IsSupersetOf(selector) {
+			matched = append(matched, WorkloadAuthorization{AuthorizationPolicy: p})
+		}
+	}
+	return matched
+}
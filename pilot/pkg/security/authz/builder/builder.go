@@ -15,24 +15,58 @@
 package builder
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 
 	tcp_filter "github.com/envoyproxy/go-control-plane/envoy/api/v2/listener"
+	ext_authz "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/ext_authz/v2"
 	http_config "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/rbac/v2"
 	http_filter "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
 	tcp_config "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/rbac/v2"
 	envoy_rbac "github.com/envoyproxy/go-control-plane/envoy/config/rbac/v2"
 
+	securityv1beta1 "istio.io/api/security/v1beta1"
 	"istio.io/istio/pilot/pkg/model"
 	"istio.io/istio/pilot/pkg/networking/util"
+	"istio.io/istio/pilot/pkg/security/authz/builder/cache"
 	authz_model "istio.io/istio/pilot/pkg/security/authz/model"
 	"istio.io/istio/pilot/pkg/security/authz/policy"
+	"istio.io/istio/pilot/pkg/security/authz/policy/jsonpolicy"
 	"istio.io/istio/pilot/pkg/security/authz/policy/v1alpha1"
 	"istio.io/istio/pilot/pkg/security/authz/policy/v1beta1"
 	"istio.io/istio/pkg/config/labels"
 	istiolog "istio.io/pkg/log"
 )
 
+// rbacConfigCache memoizes the fully marshaled HTTP/TCP filters generated by
+// Builder, keyed by the matched policies and service/workload attributes
+// that fed into them. It is process-wide: under a large push, many proxies
+// share the same namespace policies and would otherwise regenerate
+// byte-identical config.
+var rbacConfigCache = cache.New()
+
+// InvalidateCache evicts every cached RBAC filter config that was generated
+// from any of the given AuthorizationPolicy UIDs. It must be called whenever
+// model.AuthorizationPolicies observes a config event for one of those UIDs.
+func InvalidateCache(uids ...string) {
+	rbacConfigCache.Invalidate(uids...)
+}
+
+const (
+	// denyHTTPFilterName identifies the HTTP RBAC filter carrying DENY
+	// policies merged from all sources (JSON deny_rules and v1beta1 DENY
+	// policies), evaluated ahead of everything else.
+	denyHTTPFilterName = authz_model.RBACHTTPFilterName + ".deny"
+	// customHTTPFilterName identifies the HTTP RBAC filter that stamps the
+	// dynamic metadata consumed by the ext_authz filter for CUSTOM policies.
+	customHTTPFilterName = authz_model.RBACHTTPFilterName + ".custom"
+	// extAuthzHTTPFilterName is the well-known Envoy HTTP filter name for
+	// ext_authz. Each extension provider gets its own filter instance, named
+	// extAuthzHTTPFilterName plus a "." and the provider name.
+	extAuthzHTTPFilterName = "envoy.filters.http.ext_authz"
+)
+
 var (
 	rbacLog = istiolog.RegisterScope("rbac", "rbac debugging", 0)
 )
@@ -41,7 +75,17 @@ var (
 type Builder struct {
 	isXDSMarshalingToAnyEnabled bool
 	v1alpha1Generator           policy.Generator
-	v1beta1Generator            policy.Generator
+	v1beta1Generator            *v1beta1.Generator
+	jsonGenerator               *jsonpolicy.Generator
+
+	// cacheEligible, cachePolicies and cacheServiceAttrs together describe
+	// the cache.Key used to memoize this Builder's generated filters.
+	// cacheEligible is false for the legacy v1alpha1 (ServiceRole/
+	// ServiceRoleBinding) path, which does not carry a UID/ResourceVersion
+	// the cache could key on.
+	cacheEligible     bool
+	cachePolicies     []cache.PolicyRef
+	cacheServiceAttrs map[string]string
 }
 
 // NewBuilder creates a builder instance that can be used to build corresponding RBAC filter config.
@@ -81,26 +125,204 @@ func NewBuilder(serviceInstance *model.ServiceInstance, workloadLabels labels.Co
 		rbacLog.Debugf("v1beta1 authorization policies disabled for workload %v in %s",
 			workloadLabels, serviceNamespace)
 	}
+	builder.cachePolicies = policyRefs(matchedPolicies)
 
-	if builder.v1alpha1Generator == nil && builder.v1beta1Generator == nil {
+	// A JSON authorization policy document, sourced from either an annotation
+	// on the workload/service or an AuthorizationPolicyJSON CRD, can govern a
+	// workload alongside the v1alpha1/v1beta1 policies above.
+	if raw, ok := policies.JSONAuthorizationPolicyFor(serviceNamespace, workloadLabels); ok {
+		gen, err := jsonpolicy.NewGenerator([]byte(raw))
+		if err != nil {
+			rbacLog.Errorf("failed to parse JSON authorization policy for %s: %s", serviceHostname, err)
+		} else {
+			builder.jsonGenerator = gen
+			builder.cachePolicies = append(builder.cachePolicies, jsonPolicyRef([]byte(raw)))
+		}
+	}
+
+	if builder.v1alpha1Generator == nil && builder.v1beta1Generator == nil && builder.jsonGenerator == nil {
 		return nil
 	}
 
+	builder.cacheServiceAttrs = map[string]string{
+		"name":      serviceName,
+		"namespace": serviceNamespace,
+		"hostname":  serviceHostname,
+	}
+	// The v1alpha1 generator resolves ServiceRole/ServiceRoleBinding objects
+	// that carry no tracked UID/ResourceVersion today, so a Builder that uses
+	// it cannot be safely memoized.
+	builder.cacheEligible = builder.v1alpha1Generator == nil
+
 	return builder
 }
 
-// BuildHTTPFilter builds the RBAC HTTP filter.
-func (b *Builder) BuildHTTPFilter() *http_filter.HttpFilter {
-	if b == nil {
+// NewWorkloadBuilder creates a builder instance for an ambient-mode workload
+// that is addressed by pod identity rather than by a Kubernetes Service, so
+// unlike NewBuilder it does not require a model.ServiceInstance. It produces
+// the same HTTP/TCP filter outputs as NewBuilder, letting ztunnel/waypoint
+// listeners reuse the existing filter builders instead of duplicating RBAC
+// translation logic in the ambient controller.
+func NewWorkloadBuilder(workload *model.WorkloadInfo, policies *model.AuthorizationPolicies,
+	isXDSMarshalingToAnyEnabled bool) *Builder {
+	if workload == nil {
+		rbacLog.Errorf("no workload provided to NewWorkloadBuilder")
+		return nil
+	}
+
+	workloadMeta, err := authz_model.NewWorkloadMetadata(workload)
+	if err != nil {
+		rbacLog.Errorf("failed to create WorkloadMetadata for %s/%s: %s", workload.Namespace, workload.Name, err)
 		return nil
 	}
 
-	rbacConfig := b.generate(false /* forTCPFilter */)
-	if rbacConfig == nil {
+	builder := &Builder{
+		isXDSMarshalingToAnyEnabled: isXDSMarshalingToAnyEnabled,
+	}
+
+	// There is no ServiceMetadata to build here (ambient workloads have no
+	// hostname), so v1alpha1, which is hostname-scoped, is never populated.
+	matchedAuthorizations := policies.ListWorkloadAuthorizations(workload.Namespace, workload.Labels)
+	if len(matchedAuthorizations) > 0 {
+		matchedPolicies := make([]model.AuthorizationPolicy, 0, len(matchedAuthorizations))
+		for _, authz := range matchedAuthorizations {
+			matchedPolicies = append(matchedPolicies, authz.AuthorizationPolicy)
+		}
+		builder.v1beta1Generator = v1beta1.NewWorkloadGenerator(matchedPolicies, workloadMeta)
+		builder.cachePolicies = policyRefs(matchedPolicies)
+	} else {
+		rbacLog.Debugf("no authorization policies matched for workload %v in %s", workload.Labels, workload.Namespace)
+	}
+
+	if raw, ok := policies.JSONAuthorizationPolicyFor(workload.Namespace, workload.Labels); ok {
+		gen, err := jsonpolicy.NewGenerator([]byte(raw))
+		if err != nil {
+			rbacLog.Errorf("failed to parse JSON authorization policy for %s/%s: %s", workload.Namespace, workload.Name, err)
+		} else {
+			builder.jsonGenerator = gen
+			builder.cachePolicies = append(builder.cachePolicies, jsonPolicyRef([]byte(raw)))
+		}
+	}
+
+	if builder.v1beta1Generator == nil && builder.jsonGenerator == nil {
 		return nil
 	}
+
+	builder.cacheServiceAttrs = map[string]string{
+		"namespace":      workload.Namespace,
+		"name":           workload.Name,
+		"serviceAccount": workload.ServiceAccount,
+	}
+	builder.cacheEligible = true
+
+	return builder
+}
+
+// policyRefs builds the cache.PolicyRef slice identifying policies for the
+// cache key. Every policy is currently deterministic to translate, including
+// "when" conditions: those are embedded as Envoy-side matchers and evaluated
+// by Envoy at request time, not by Pilot at generation time, so the
+// Cacheable hook defaults to true.
+func policyRefs(policies []model.AuthorizationPolicy) []cache.PolicyRef {
+	if len(policies) == 0 {
+		return nil
+	}
+	refs := make([]cache.PolicyRef, 0, len(policies))
+	for _, p := range policies {
+		refs = append(refs, cache.PolicyRef{UID: p.UID, ResourceVersion: p.ResourceVersion, Cacheable: true})
+	}
+	return refs
+}
+
+// jsonPolicyRef builds a cache.PolicyRef for a JSON authorization policy
+// document, which has no Kubernetes UID/ResourceVersion of its own: the
+// document's content hash stands in for both, so any edit is automatically
+// a cache miss.
+func jsonPolicyRef(raw []byte) cache.PolicyRef {
+	sum := sha256.Sum256(raw)
+	return cache.PolicyRef{UID: "json:" + hex.EncodeToString(sum[:]), Cacheable: true}
+}
+
+// BuildHTTPFilters builds the RBAC HTTP filters for the workload, in the
+// order Envoy must evaluate them: DENY first, then CUSTOM (delegating to
+// ext_authz), then the merged ALLOW configuration. Unless the Builder uses
+// the v1alpha1 generator (cacheEligible is false), the result is memoized in
+// rbacConfigCache, keyed by the policies and service/workload attributes
+// that produced it; callers always get back their own slice, never the
+// cached one, so they are free to mutate or append to it.
+func (b *Builder) BuildHTTPFilters() []*http_filter.HttpFilter {
+	if b == nil {
+		return nil
+	}
+
+	if !b.cacheEligible {
+		return b.buildHTTPFilters()
+	}
+
+	key := b.cacheKey(false /* forTCPFilter */)
+	if cached, ok := rbacConfigCache.Get(key); ok {
+		return copyHTTPFilters(cached.([]*http_filter.HttpFilter))
+	}
+	filters := b.buildHTTPFilters()
+	rbacConfigCache.Set(key, filters)
+	// Return a copy: the cache entry is shared across every concurrent proxy
+	// push that hits this key, and callers are free to append to the slice
+	// they get back while assembling a filter chain.
+	return copyHTTPFilters(filters)
+}
+
+func copyHTTPFilters(filters []*http_filter.HttpFilter) []*http_filter.HttpFilter {
+	return append([]*http_filter.HttpFilter(nil), filters...)
+}
+
+func (b *Builder) buildHTTPFilters() []*http_filter.HttpFilter {
+	cfg := b.generate(false /* forTCPFilter */)
+
+	var filters []*http_filter.HttpFilter
+	if cfg.deny != nil {
+		filters = append(filters, b.wrapHTTPConfig(denyHTTPFilterName, cfg.deny))
+	}
+	if cfg.custom != nil {
+		filters = append(filters, b.wrapHTTPConfig(customHTTPFilterName, cfg.custom))
+		for _, named := range cfg.extAuthz.ToEnvoyConfigs() {
+			name := extAuthzHTTPFilterName + "." + named.Provider
+			filters = append(filters, wrapExtAuthzConfig(name, b.isXDSMarshalingToAnyEnabled, named.Config))
+		}
+	}
+	if cfg.allow != nil {
+		filters = append(filters, b.wrapHTTPConfig(authz_model.RBACHTTPFilterName, cfg.allow))
+	}
+
+	return filters
+}
+
+// cacheKey builds the cache.Key for this Builder's filters for the given
+// filter kind.
+func (b *Builder) cacheKey(forTCPFilter bool) cache.Key {
+	return cache.Key{
+		Policies:                    b.cachePolicies,
+		ServiceAttributes:           b.cacheServiceAttrs,
+		ForTCPFilter:                forTCPFilter,
+		IsXDSMarshalingToAnyEnabled: b.isXDSMarshalingToAnyEnabled,
+	}
+}
+
+func wrapExtAuthzConfig(name string, isXDSMarshalingToAnyEnabled bool, extAuthzConfig *ext_authz.ExtAuthz) *http_filter.HttpFilter {
 	httpConfig := http_filter.HttpFilter{
-		Name: authz_model.RBACHTTPFilterName,
+		Name: name,
+	}
+	if isXDSMarshalingToAnyEnabled {
+		httpConfig.ConfigType = &http_filter.HttpFilter_TypedConfig{TypedConfig: util.MessageToAny(extAuthzConfig)}
+	} else {
+		httpConfig.ConfigType = &http_filter.HttpFilter_Config{Config: util.MessageToStruct(extAuthzConfig)}
+	}
+	rbacLog.Debugf("built ext_authz http filter config: %v", httpConfig)
+	return &httpConfig
+}
+
+func (b *Builder) wrapHTTPConfig(name string, rbacConfig *http_config.RBAC) *http_filter.HttpFilter {
+	httpConfig := http_filter.HttpFilter{
+		Name: name,
 	}
 	if b.isXDSMarshalingToAnyEnabled {
 		httpConfig.ConfigType = &http_filter.HttpFilter_TypedConfig{TypedConfig: util.MessageToAny(rbacConfig)}
@@ -112,18 +334,56 @@ func (b *Builder) BuildHTTPFilter() *http_filter.HttpFilter {
 	return &httpConfig
 }
 
-// BuildTCPFilter builds the RBAC TCP filter.
-func (b *Builder) BuildTCPFilter() *tcp_filter.Filter {
+// BuildTCPFilters builds the RBAC TCP filters for the workload, in the same
+// evaluation order as BuildHTTPFilters. CUSTOM policies have no TCP-level
+// equivalent of ext_authz, so only their RBAC (metadata-stamping) config is
+// included; the workload falls back to being governed by DENY/ALLOW alone.
+// Like BuildHTTPFilters, the result is memoized in rbacConfigCache unless the
+// Builder uses the v1alpha1 generator, and callers always get back their own
+// slice, never the cached one.
+func (b *Builder) BuildTCPFilters() []*tcp_filter.Filter {
 	if b == nil {
 		return nil
 	}
 
-	// The build function always return the config for HTTP filter, we need to extract the
-	// generated rules and set it in the config for TCP filter.
-	config := b.generate(true /* forTCPFilter */)
-	if config == nil {
-		return nil
+	if !b.cacheEligible {
+		return b.buildTCPFilters()
+	}
+
+	key := b.cacheKey(true /* forTCPFilter */)
+	if cached, ok := rbacConfigCache.Get(key); ok {
+		return copyTCPFilters(cached.([]*tcp_filter.Filter))
+	}
+	filters := b.buildTCPFilters()
+	rbacConfigCache.Set(key, filters)
+	// Return a copy: the cache entry is shared across every concurrent proxy
+	// push that hits this key, and callers are free to append to the slice
+	// they get back while assembling a filter chain.
+	return copyTCPFilters(filters)
+}
+
+func copyTCPFilters(filters []*tcp_filter.Filter) []*tcp_filter.Filter {
+	return append([]*tcp_filter.Filter(nil), filters...)
+}
+
+func (b *Builder) buildTCPFilters() []*tcp_filter.Filter {
+	cfg := b.generate(true /* forTCPFilter */)
+
+	var filters []*tcp_filter.Filter
+	if cfg.deny != nil {
+		filters = append(filters, b.wrapTCPConfig(authz_model.RBACTCPFilterName+".deny", cfg.deny))
+	}
+	if cfg.custom != nil {
+		filters = append(filters, b.wrapTCPConfig(authz_model.RBACTCPFilterName+".custom", cfg.custom))
+	}
+	if cfg.allow != nil {
+		filters = append(filters, b.wrapTCPConfig(authz_model.RBACTCPFilterName, cfg.allow))
 	}
+
+	return filters
+}
+
+func (b *Builder) wrapTCPConfig(name string, config *http_config.RBAC) *tcp_filter.Filter {
 	rbacConfig := &tcp_config.RBAC{
 		Rules:       config.Rules,
 		ShadowRules: config.ShadowRules,
@@ -131,7 +391,7 @@ func (b *Builder) BuildTCPFilter() *tcp_filter.Filter {
 	}
 
 	tcpConfig := tcp_filter.Filter{
-		Name: authz_model.RBACTCPFilterName,
+		Name: name,
 	}
 	if b.isXDSMarshalingToAnyEnabled {
 		tcpConfig.ConfigType = &tcp_filter.Filter_TypedConfig{TypedConfig: util.MessageToAny(rbacConfig)}
@@ -143,39 +403,95 @@ func (b *Builder) BuildTCPFilter() *tcp_filter.Filter {
 	return &tcpConfig
 }
 
-func (b *Builder) generate(forTCPFilter bool) *http_config.RBAC {
+// generatedConfigs holds the per-action RBAC filter configs produced by
+// generate, plus any ext_authz config required by CUSTOM policies. DENY and
+// CUSTOM are kept apart from the ALLOW merge so Builder can order the
+// resulting filters DENY, CUSTOM, ALLOW as Envoy RBAC semantics require.
+type generatedConfigs struct {
+	deny     *http_config.RBAC
+	custom   *http_config.RBAC
+	allow    *http_config.RBAC
+	extAuthz *v1beta1.ExtAuthzConfig
+}
+
+func (b *Builder) generate(forTCPFilter bool) *generatedConfigs {
+	cfg := &generatedConfigs{}
+
+	// JSON deny_rules are merged into cfg.deny; v1beta1 DENY policies below
+	// are merged into the same filter so only one DENY RBAC filter is ever
+	// emitted.
+	if b.jsonGenerator != nil {
+		cfg.deny = b.jsonGenerator.GenerateDeny(forTCPFilter)
+		rbacLog.Debugf("generated deny filter config from JSON policy: %v", cfg.deny)
+	}
+
 	var v1alpha1Config *http_config.RBAC
 	if b.v1alpha1Generator != nil {
 		v1alpha1Config = b.v1alpha1Generator.Generate(forTCPFilter)
 		rbacLog.Debugf("generated filter config from v1alpha1 policy: %v", v1alpha1Config)
 	}
 
-	var v1beta1Config *http_config.RBAC
+	var v1beta1AllowConfig *http_config.RBAC
 	if b.v1beta1Generator != nil {
-		v1beta1Config = b.v1beta1Generator.Generate(forTCPFilter)
-		rbacLog.Debugf("generated filter config from v1beta1 policy: %v", v1beta1Config)
+		for _, rbac := range b.v1beta1Generator.GenerateRBAC(forTCPFilter) {
+			switch rbac.Action {
+			case securityv1beta1.AuthorizationPolicy_DENY:
+				if cfg.deny == nil {
+					cfg.deny = rbac.Config
+				} else {
+					mergeRBACPolicies(cfg.deny, rbac.Config, "authz-v1beta1-deny")
+				}
+			case securityv1beta1.AuthorizationPolicy_CUSTOM:
+				cfg.custom = rbac.Config
+			default:
+				v1beta1AllowConfig = rbac.Config
+			}
+		}
+		cfg.extAuthz = b.v1beta1Generator.GenerateExtAuthz()
+		rbacLog.Debugf("generated filter config from v1beta1 policy: allow=%v custom=%v", v1beta1AllowConfig, cfg.custom)
 	}
 
-	if v1alpha1Config == nil && v1beta1Config == nil {
-		rbacLog.Errorf("No RBAC filter config generator available")
-		return nil
-	} else if v1alpha1Config == nil {
-		return v1beta1Config
-	} else if v1beta1Config == nil {
-		return v1alpha1Config
+	var jsonAllowConfig *http_config.RBAC
+	if b.jsonGenerator != nil {
+		jsonAllowConfig = b.jsonGenerator.Generate(forTCPFilter)
+		rbacLog.Debugf("generated filter config from JSON policy: %v", jsonAllowConfig)
 	}
 
-	if v1alpha1Config.Rules == nil {
-		v1alpha1Config.Rules = &envoy_rbac.RBAC{}
+	cfg.allow = v1alpha1Config
+	if cfg.allow == nil {
+		cfg.allow = v1beta1AllowConfig
+		v1beta1AllowConfig = nil
+	}
+	if cfg.allow == nil {
+		cfg.allow = jsonAllowConfig
+		jsonAllowConfig = nil
+	}
+	if cfg.allow == nil {
+		rbacLog.Debugf("no ALLOW RBAC filter config generator available")
+	} else {
+		mergeRBACPolicies(cfg.allow, v1beta1AllowConfig, "authz-v1beta1-merged")
+		mergeRBACPolicies(cfg.allow, jsonAllowConfig, "authz-json-merged")
+	}
+
+	rbacLog.Debugf("generated RBAC configs: %v", cfg)
+	return cfg
+}
+
+// mergeRBACPolicies folds the policies of src into dst, prefixing each
+// policy name with prefix to avoid collisions between sources. The shadow
+// rules of src, if any, are not merged; the configs merged here (v1beta1 and
+// JSON ALLOW) set only Rules.
+func mergeRBACPolicies(dst, src *http_config.RBAC, prefix string) {
+	if src == nil {
+		return
+	}
+	if dst.Rules == nil {
+		dst.Rules = &envoy_rbac.RBAC{}
 	}
-	if v1alpha1Config.Rules.Policies == nil {
-		v1alpha1Config.Rules.Policies = map[string]*envoy_rbac.Policy{}
+	if dst.Rules.Policies == nil {
+		dst.Rules.Policies = map[string]*envoy_rbac.Policy{}
 	}
-	// Only need to merge rules, the shadow rules is not supported in v1beta1.
-	for k, v := range v1beta1Config.GetRules().GetPolicies() {
-		name := fmt.Sprintf("authz-v1beta1-merged[%s]", k)
-		v1alpha1Config.Rules.Policies[name] = v
+	for k, v := range src.GetRules().GetPolicies() {
+		dst.Rules.Policies[fmt.Sprintf("%s[%s]", prefix, k)] = v
 	}
-	rbacLog.Debugf("merged v1beta1 to v1alpha1 config: %v", v1alpha1Config)
-	return v1alpha1Config
 }
@@ -0,0 +1,213 @@
+// Copyright 2023 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cache provides a process-wide, content-addressed cache of
+// generated RBAC filter configs. Under a large push, Builder.generate would
+// otherwise be called once per proxy and re-walk every matched
+// AuthorizationPolicy to build identical envoy_rbac.RBAC protos even though
+// hundreds of sidecars can share the same namespace policies.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+
+	lru "github.com/hashicorp/golang-lru"
+
+	"istio.io/pkg/monitoring"
+)
+
+var (
+	cacheHits = monitoring.NewSum(
+		"pilot_rbac_cache_hits_total",
+		"Total number of times a generated RBAC filter config was served from cache.",
+	)
+	cacheMisses = monitoring.NewSum(
+		"pilot_rbac_cache_misses_total",
+		"Total number of times a generated RBAC filter config had to be rebuilt.",
+	)
+)
+
+func init() {
+	monitoring.MustRegister(cacheHits, cacheMisses)
+}
+
+// defaultSize bounds the number of distinct (policy set, service, filter
+// kind) combinations memoized at once. It is sized generously since entries
+// are small protos, not full xDS snapshots.
+const defaultSize = 4096
+
+// PolicyRef pins one of the AuthorizationPolicy objects that contributed to
+// a generated config to the resource version it was generated from, so that
+// an edit to that policy is reflected in the cache key without the cache
+// needing to inspect policy contents itself.
+type PolicyRef struct {
+	UID             string
+	ResourceVersion string
+
+	// Cacheable is a hook for future dynamic AuthorizationPolicy extensions
+	// whose generated config cannot be memoized (e.g. because it would
+	// depend on state outside the policy object itself). No such extension
+	// exists today: every policy translates deterministically into Envoy
+	// config, including "when" conditions, since those are evaluated by
+	// Envoy at request time rather than by Pilot at generation time.
+	Cacheable bool
+}
+
+// Key is the cache key for a generated RBAC filter config.
+type Key struct {
+	Policies                    []PolicyRef
+	ServiceAttributes           map[string]string
+	ForTCPFilter                bool
+	IsXDSMarshalingToAnyEnabled bool
+}
+
+// Hash returns a stable, content-addressed string for k.
+func (k Key) Hash() string {
+	refs := append([]PolicyRef(nil), k.Policies...)
+	sort.Slice(refs, func(i, j int) bool { return refs[i].UID < refs[j].UID })
+
+	attrKeys := make([]string, 0, len(k.ServiceAttributes))
+	for attr := range k.ServiceAttributes {
+		attrKeys = append(attrKeys, attr)
+	}
+	sort.Strings(attrKeys)
+
+	h := sha256.New()
+	for _, ref := range refs {
+		fmt.Fprintf(h, "policy=%s@%s;", ref.UID, ref.ResourceVersion)
+	}
+	for _, attr := range attrKeys {
+		fmt.Fprintf(h, "%s=%s;", attr, k.ServiceAttributes[attr])
+	}
+	fmt.Fprintf(h, "tcp=%t;any=%t", k.ForTCPFilter, k.IsXDSMarshalingToAnyEnabled)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cacheable reports whether every PolicyRef in k allows caching.
+func (k Key) cacheable() bool {
+	for _, ref := range k.Policies {
+		if !ref.Cacheable {
+			return false
+		}
+	}
+	return true
+}
+
+// Cache memoizes generated filter configs (entry is opaque to the cache;
+// Builder stores the fully marshaled *any.Any/*structpb.Struct filters) by
+// Key.Hash, and tracks which cache entries each policy UID contributed to
+// so they can be dropped precisely when that policy changes. byUID and
+// uidsByHash are kept in sync with entries' own LRU eviction via
+// lru.NewWithEvict, not only via explicit Invalidate: otherwise byUID would
+// grow unbounded as entries is capacity-evicted under the high key
+// cardinality (hundreds of sidecars' worth of distinct policy sets) this
+// cache targets.
+type Cache struct {
+	mu         sync.Mutex
+	entries    *lru.Cache
+	byUID      map[string]map[string]struct{}
+	uidsByHash map[string][]string
+}
+
+// New creates an empty Cache holding at most defaultSize entries.
+func New() *Cache {
+	c := &Cache{
+		byUID:      map[string]map[string]struct{}{},
+		uidsByHash: map[string][]string{},
+	}
+	entries, err := lru.NewWithEvict(defaultSize, c.onEvicted)
+	if err != nil {
+		// Only returns an error for a non-positive size, which defaultSize never is.
+		panic(err)
+	}
+	c.entries = entries
+	return c
+}
+
+// onEvicted drops hash from byUID, for every UID it was indexed under, when
+// entries evicts it - whether via Remove (from Invalidate) or via its own
+// capacity-based LRU eviction. Callers always hold c.mu while entries can
+// evict, so this needs no locking of its own.
+func (c *Cache) onEvicted(key, _ interface{}) {
+	hash := key.(string)
+	for _, uid := range c.uidsByHash[hash] {
+		if keys, ok := c.byUID[uid]; ok {
+			delete(keys, hash)
+			if len(keys) == 0 {
+				delete(c.byUID, uid)
+			}
+		}
+	}
+	delete(c.uidsByHash, hash)
+}
+
+// Get returns the cached value for key, if any.
+func (c *Cache) Get(key Key) (interface{}, bool) {
+	if !key.cacheable() {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.entries.Get(key.Hash())
+	if ok {
+		cacheHits.Increment()
+	} else {
+		cacheMisses.Increment()
+	}
+	return v, ok
+}
+
+// Set stores value under key, indexing it by every policy UID referenced by
+// key so a later Invalidate for that UID evicts it.
+func (c *Cache) Set(key Key, value interface{}) {
+	if !key.cacheable() {
+		return
+	}
+	hash := key.Hash()
+	uids := make([]string, 0, len(key.Policies))
+	for _, ref := range key.Policies {
+		uids = append(uids, ref.UID)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries.Add(hash, value)
+	c.uidsByHash[hash] = uids
+	for _, uid := range uids {
+		keys, ok := c.byUID[uid]
+		if !ok {
+			keys = map[string]struct{}{}
+			c.byUID[uid] = keys
+		}
+		keys[hash] = struct{}{}
+	}
+}
+
+// Invalidate evicts every cache entry that was generated from any of the
+// given policy UIDs. Call this whenever AuthorizationPolicies receives a
+// config event for one of those UIDs. onEvicted does the actual byUID/
+// uidsByHash bookkeeping as entries.Remove evicts each hash.
+func (c *Cache) Invalidate(uids ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, uid := range uids {
+		for hash := range c.byUID[uid] {
+			c.entries.Remove(hash)
+		}
+	}
+}
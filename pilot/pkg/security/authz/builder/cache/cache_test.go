@@ -0,0 +1,129 @@
+// Copyright 2023 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestCache_GetSetMiss(t *testing.T) {
+	c := New()
+	key := Key{Policies: []PolicyRef{{UID: "a", ResourceVersion: "1", Cacheable: true}}}
+
+	if _, ok := c.Get(key); ok {
+		t.Fatalf("got a hit before any Set")
+	}
+
+	c.Set(key, "value")
+	got, ok := c.Get(key)
+	if !ok || got != "value" {
+		t.Fatalf("Get() = (%v, %v), want (\"value\", true)", got, ok)
+	}
+}
+
+func TestCache_NotCacheableBypassesStorage(t *testing.T) {
+	c := New()
+	key := Key{Policies: []PolicyRef{{UID: "a", ResourceVersion: "1", Cacheable: false}}}
+
+	c.Set(key, "value")
+	if _, ok := c.Get(key); ok {
+		t.Fatalf("got a hit for a Cacheable:false key, want every Set to be a no-op")
+	}
+	if c.entries.Len() != 0 {
+		t.Fatalf("entries has %d items, want 0 for a Cacheable:false key", c.entries.Len())
+	}
+}
+
+func TestCache_InvalidateDropsOnlyMatchingUIDs(t *testing.T) {
+	c := New()
+	keyA := Key{Policies: []PolicyRef{{UID: "a", ResourceVersion: "1", Cacheable: true}}}
+	keyB := Key{Policies: []PolicyRef{{UID: "b", ResourceVersion: "1", Cacheable: true}}}
+	c.Set(keyA, "a-value")
+	c.Set(keyB, "b-value")
+
+	c.Invalidate("a")
+
+	if _, ok := c.Get(keyA); ok {
+		t.Errorf("keyA still cached after Invalidate(\"a\")")
+	}
+	if _, ok := c.Get(keyB); !ok {
+		t.Errorf("keyB was dropped by Invalidate(\"a\"), want it untouched")
+	}
+	if _, ok := c.byUID["a"]; ok {
+		t.Errorf("byUID still has an entry for \"a\" after Invalidate")
+	}
+}
+
+func TestCache_InvalidateDropsEntryReferencedByMultipleUIDs(t *testing.T) {
+	c := New()
+	key := Key{Policies: []PolicyRef{
+		{UID: "a", ResourceVersion: "1", Cacheable: true},
+		{UID: "b", ResourceVersion: "1", Cacheable: true},
+	}}
+	c.Set(key, "value")
+
+	c.Invalidate("a")
+
+	if _, ok := c.Get(key); ok {
+		t.Errorf("entry survived Invalidate of one of its two contributing UIDs")
+	}
+	if _, ok := c.byUID["b"]; ok {
+		t.Errorf("byUID still has an entry for \"b\" after its only entry was invalidated via \"a\"")
+	}
+}
+
+// TestCache_LRUEvictionCleansUpByUID is a regression test: byUID must not
+// grow unbounded when entries evicts a key on its own, under pressure from
+// the LRU's fixed capacity, rather than via an explicit Invalidate.
+func TestCache_LRUEvictionCleansUpByUID(t *testing.T) {
+	c := New()
+	for i := 0; i < defaultSize+10; i++ {
+		uid := string(rune('a' + i%26))
+		key := Key{Policies: []PolicyRef{{UID: uid, ResourceVersion: "1", Cacheable: true}},
+			ServiceAttributes: map[string]string{"i": string(rune(i))}}
+		c.Set(key, i)
+	}
+
+	if c.entries.Len() > defaultSize {
+		t.Fatalf("entries has %d items, want at most %d", c.entries.Len(), defaultSize)
+	}
+	if len(c.uidsByHash) > defaultSize {
+		t.Errorf("uidsByHash has %d items, want at most %d (stale entries were not cleaned up on eviction)", len(c.uidsByHash), defaultSize)
+	}
+	totalIndexed := 0
+	for _, hashes := range c.byUID {
+		totalIndexed += len(hashes)
+	}
+	if totalIndexed > defaultSize {
+		t.Errorf("byUID indexes %d hashes across all UIDs, want at most %d (stale entries were not cleaned up on eviction)", totalIndexed, defaultSize)
+	}
+}
+
+func TestCache_ConcurrentGetSet(t *testing.T) {
+	c := New()
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key := Key{Policies: []PolicyRef{{UID: string(rune('a' + i%26)), ResourceVersion: "1", Cacheable: true}}}
+			c.Set(key, i)
+			c.Get(key)
+		}()
+	}
+	wg.Wait()
+}
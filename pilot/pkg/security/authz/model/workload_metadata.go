@@ -0,0 +1,42 @@
+// Copyright 2022 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"fmt"
+
+	"istio.io/istio/pilot/pkg/model"
+)
+
+// WorkloadMetadata marks a v1beta1 generator as translating rules for an
+// ambient-mode workload, in place of the ServiceMetadata used for sidecar
+// workloads fronted by a Kubernetes Service. There is no hostname: ambient
+// workloads are addressed by pod identity, not by Service. It carries no
+// attributes of its own today; its presence (non-nil) is what tells the
+// generator to skip the :authority host dimension, since there is no
+// Service hostname for an ambient workload to match against.
+type WorkloadMetadata struct{}
+
+// NewWorkloadMetadata builds a WorkloadMetadata for workload.
+func NewWorkloadMetadata(workload *model.WorkloadInfo) (*WorkloadMetadata, error) {
+	if workload == nil {
+		return nil, fmt.Errorf("no workload provided")
+	}
+	if workload.Namespace == "" {
+		return nil, fmt.Errorf("workload %s has no namespace", workload.Name)
+	}
+
+	return &WorkloadMetadata{}, nil
+}
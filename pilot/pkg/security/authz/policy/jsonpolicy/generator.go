@@ -0,0 +1,141 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jsonpolicy
+
+import (
+	"strconv"
+
+	http_config "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/rbac/v2"
+	envoy_rbac "github.com/envoyproxy/go-control-plane/envoy/config/rbac/v2"
+
+	"istio.io/istio/pilot/pkg/security/authz/policy"
+)
+
+// Generator implements policy.Generator for a single raw JSON authorization
+// policy document. Generate returns the allow rules; the deny rules, which
+// must be enforced by a separate RBAC filter ahead of the allow one, are
+// exposed through GenerateDeny.
+type Generator struct {
+	policy *Policy
+}
+
+// NewGenerator parses raw as a JSON authorization policy document and
+// returns a Generator for it.
+func NewGenerator(raw []byte) (*Generator, error) {
+	p, err := ParsePolicy(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &Generator{policy: p}, nil
+}
+
+// Generate implements policy.Generator. It returns the RBAC config for the
+// allow_rules of the policy, or nil if there are none.
+func (g *Generator) Generate(forTCPFilter bool) *http_config.RBAC {
+	return rulesToRBAC(g.policy.Name, g.policy.AllowRules, envoy_rbac.RBAC_ALLOW)
+}
+
+// GenerateDeny returns the RBAC config for the deny_rules of the policy, or
+// nil if there are none. It must be evaluated ahead of the config returned
+// by Generate, since deny always takes precedence over allow.
+func (g *Generator) GenerateDeny(forTCPFilter bool) *http_config.RBAC {
+	return rulesToRBAC(g.policy.Name, g.policy.DenyRules, envoy_rbac.RBAC_DENY)
+}
+
+func rulesToRBAC(name string, rules []Rule, action envoy_rbac.RBAC_Action) *http_config.RBAC {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	policies := map[string]*envoy_rbac.Policy{}
+	for i, rule := range rules {
+		policies[policyName(name, action, i)] = ruleToPolicy(rule)
+	}
+
+	return &http_config.RBAC{
+		Rules: &envoy_rbac.RBAC{
+			Action:   action,
+			Policies: policies,
+		},
+	}
+}
+
+func policyName(name string, action envoy_rbac.RBAC_Action, i int) string {
+	return name + "-" + action.String() + "-" + strconv.Itoa(i)
+}
+
+func ruleToPolicy(rule Rule) *envoy_rbac.Policy {
+	p := &envoy_rbac.Policy{}
+
+	for _, principal := range rule.Source.Principals {
+		p.Principals = append(p.Principals, &envoy_rbac.Principal{
+			Identifier: &envoy_rbac.Principal_Authenticated_{
+				Authenticated: &envoy_rbac.Principal_Authenticated{
+					PrincipalName: stringToMatcher(principal),
+				},
+			},
+		})
+	}
+	if len(p.Principals) == 0 {
+		p.Principals = []*envoy_rbac.Principal{{Identifier: &envoy_rbac.Principal_Any{Any: true}}}
+	}
+
+	// Dimensions (one per header name, plus paths) are ANDed together; the
+	// values within a single dimension are OR'd, so e.g.
+	// {"headers":[{"name":"x-env","values":["prod","staging"]}]} matches
+	// either value rather than requiring both simultaneously.
+	var dimensions []*envoy_rbac.Permission
+	for _, h := range rule.Request.Headers {
+		if d := orHeaderPermissions(h.Name, h.Values); d != nil {
+			dimensions = append(dimensions, d)
+		}
+	}
+	if d := orHeaderPermissions(":path", rule.Request.Paths); d != nil {
+		dimensions = append(dimensions, d)
+	}
+
+	switch len(dimensions) {
+	case 0:
+		p.Permissions = []*envoy_rbac.Permission{{Rule: &envoy_rbac.Permission_Any{Any: true}}}
+	case 1:
+		p.Permissions = []*envoy_rbac.Permission{dimensions[0]}
+	default:
+		p.Permissions = []*envoy_rbac.Permission{{
+			Rule: &envoy_rbac.Permission_AndRules{AndRules: &envoy_rbac.Permission_Set{Rules: dimensions}},
+		}}
+	}
+
+	return p
+}
+
+// orHeaderPermissions builds a single Permission matching any one of values
+// against the header named name, or nil if values is empty.
+func orHeaderPermissions(name string, values []string) *envoy_rbac.Permission {
+	if len(values) == 0 {
+		return nil
+	}
+	perms := make([]*envoy_rbac.Permission, 0, len(values))
+	for _, v := range values {
+		perms = append(perms, &envoy_rbac.Permission{
+			Rule: &envoy_rbac.Permission_Header{Header: stringToHeaderMatcher(name, v)},
+		})
+	}
+	if len(perms) == 1 {
+		return perms[0]
+	}
+	return &envoy_rbac.Permission{Rule: &envoy_rbac.Permission_OrRules{OrRules: &envoy_rbac.Permission_Set{Rules: perms}}}
+}
+
+var _ policy.Generator = &Generator{}
@@ -0,0 +1,97 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jsonpolicy
+
+import (
+	"testing"
+
+	envoy_rbac "github.com/envoyproxy/go-control-plane/envoy/config/rbac/v2"
+)
+
+// countOrRules reports how many header values the OR_rules set at the
+// top of perm matches, or 1 if perm is a single leaf (non-OR) permission.
+func countOrRules(t *testing.T, perm *envoy_rbac.Permission) int {
+	t.Helper()
+	if set, ok := perm.Rule.(*envoy_rbac.Permission_OrRules); ok {
+		return len(set.OrRules.Rules)
+	}
+	return 1
+}
+
+func TestRuleToPolicy_HeaderValuesAreOred(t *testing.T) {
+	rule := Rule{
+		Request: Request{
+			Headers: []Header{{Name: "x-env", Values: []string{"prod", "staging"}}},
+		},
+	}
+
+	p := ruleToPolicy(rule)
+
+	if len(p.Permissions) != 1 {
+		t.Fatalf("got %d top-level permissions, want 1", len(p.Permissions))
+	}
+	if got := countOrRules(t, p.Permissions[0]); got != 2 {
+		t.Errorf("header values: got %d OR'd rules, want 2 (prod, staging OR'd together)", got)
+	}
+}
+
+func TestRuleToPolicy_DimensionsAreAnded(t *testing.T) {
+	rule := Rule{
+		Request: Request{
+			Headers: []Header{{Name: "x-env", Values: []string{"prod", "staging"}}},
+			Paths:   []string{"/books/*", "/reviews/*"},
+		},
+	}
+
+	p := ruleToPolicy(rule)
+
+	if len(p.Permissions) != 1 {
+		t.Fatalf("got %d top-level permissions, want 1", len(p.Permissions))
+	}
+	and, ok := p.Permissions[0].Rule.(*envoy_rbac.Permission_AndRules)
+	if !ok {
+		t.Fatalf("top-level permission is %T, want Permission_AndRules combining the header and path dimensions", p.Permissions[0].Rule)
+	}
+	if len(and.AndRules.Rules) != 2 {
+		t.Fatalf("got %d ANDed dimensions, want 2 (headers, paths)", len(and.AndRules.Rules))
+	}
+	for i, dim := range and.AndRules.Rules {
+		if got := countOrRules(t, dim); got != 2 {
+			t.Errorf("dimension %d: got %d OR'd values, want 2", i, got)
+		}
+	}
+}
+
+func TestRuleToPolicy_NoDimensionsMatchesAny(t *testing.T) {
+	p := ruleToPolicy(Rule{})
+
+	if len(p.Permissions) != 1 {
+		t.Fatalf("got %d permissions, want 1", len(p.Permissions))
+	}
+	if _, ok := p.Permissions[0].Rule.(*envoy_rbac.Permission_Any); !ok {
+		t.Errorf("permission is %T, want Permission_Any when the rule has no headers or paths", p.Permissions[0].Rule)
+	}
+}
+
+func TestRuleToPolicy_NoPrincipalsMatchesAny(t *testing.T) {
+	p := ruleToPolicy(Rule{})
+
+	if len(p.Principals) != 1 {
+		t.Fatalf("got %d principals, want 1", len(p.Principals))
+	}
+	if _, ok := p.Principals[0].Identifier.(*envoy_rbac.Principal_Any); !ok {
+		t.Errorf("principal is %T, want Principal_Any when the rule has no source.principals", p.Principals[0].Identifier)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jsonpolicy
+
+import (
+	"strings"
+
+	route "github.com/envoyproxy/go-control-plane/envoy/api/v2/route"
+	matcher "github.com/envoyproxy/go-control-plane/envoy/type/matcher"
+)
+
+// anyMatchRegex is used whenever a value should match anything. Envoy rejects
+// an empty prefix matcher, so "*" is always translated to a regex instead of
+// StringMatcher_Prefix{Prefix: ""}.
+const anyMatchRegex = ".*"
+
+// stringToMatcher translates a JSON policy string value into an Envoy
+// StringMatcher, following the gRPC SDK authorization policy convention:
+//   - "*"        matches any value
+//   - "prefix*"  matches values with the given prefix
+//   - "*suffix"  matches values with the given suffix
+//   - otherwise  matches the value exactly
+func stringToMatcher(v string) *matcher.StringMatcher {
+	switch {
+	case v == "*":
+		return &matcher.StringMatcher{
+			MatchPattern: &matcher.StringMatcher_SafeRegex{
+				SafeRegex: &matcher.RegexMatcher{
+					EngineType: &matcher.RegexMatcher_GoogleRe2{GoogleRe2: &matcher.RegexMatcher_GoogleRE2{}},
+					Regex:      anyMatchRegex,
+				},
+			},
+		}
+	case strings.HasSuffix(v, "*"):
+		return &matcher.StringMatcher{
+			MatchPattern: &matcher.StringMatcher_Prefix{Prefix: strings.TrimSuffix(v, "*")},
+		}
+	case strings.HasPrefix(v, "*"):
+		return &matcher.StringMatcher{
+			MatchPattern: &matcher.StringMatcher_Suffix{Suffix: strings.TrimPrefix(v, "*")},
+		}
+	default:
+		return &matcher.StringMatcher{
+			MatchPattern: &matcher.StringMatcher_Exact{Exact: v},
+		}
+	}
+}
+
+// stringToHeaderMatcher translates a JSON policy string value into an Envoy
+// HeaderMatcher for the header named name, using the same "*"/"prefix*"/
+// "*suffix"/exact rules as stringToMatcher.
+func stringToHeaderMatcher(name, v string) *route.HeaderMatcher {
+	hm := &route.HeaderMatcher{Name: name}
+	switch {
+	case v == "*":
+		hm.HeaderMatchSpecifier = &route.HeaderMatcher_SafeRegexMatch{
+			SafeRegexMatch: &matcher.RegexMatcher{
+				EngineType: &matcher.RegexMatcher_GoogleRe2{GoogleRe2: &matcher.RegexMatcher_GoogleRE2{}},
+				Regex:      anyMatchRegex,
+			},
+		}
+	case strings.HasSuffix(v, "*"):
+		hm.HeaderMatchSpecifier = &route.HeaderMatcher_PrefixMatch{PrefixMatch: strings.TrimSuffix(v, "*")}
+	case strings.HasPrefix(v, "*"):
+		hm.HeaderMatchSpecifier = &route.HeaderMatcher_SuffixMatch{SuffixMatch: strings.TrimPrefix(v, "*")}
+	default:
+		hm.HeaderMatchSpecifier = &route.HeaderMatcher_ExactMatch{ExactMatch: v}
+	}
+	return hm
+}
@@ -0,0 +1,67 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package jsonpolicy compiles a raw JSON authorization policy document,
+// shaped after the gRPC SDK authorization policy, directly into Envoy RBAC
+// configuration. Unlike the v1alpha1 and v1beta1 generators it does not
+// round-trip through any Istio CRD type: the JSON is translated straight
+// into envoy_rbac.RBAC rules.
+package jsonpolicy
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Policy is the top-level JSON authorization policy document.
+type Policy struct {
+	Name       string `json:"name"`
+	DenyRules  []Rule `json:"deny_rules"`
+	AllowRules []Rule `json:"allow_rules"`
+}
+
+// Rule is a single allow or deny rule in the JSON policy document.
+type Rule struct {
+	Source  Source  `json:"source"`
+	Request Request `json:"request"`
+}
+
+// Source describes who the rule applies to.
+type Source struct {
+	Principals []string `json:"principals"`
+}
+
+// Request describes what the rule matches on the incoming request.
+type Request struct {
+	Headers []Header `json:"headers"`
+	Paths   []string `json:"paths"`
+}
+
+// Header matches a request header by name against one of Values.
+type Header struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+// ParsePolicy parses a raw JSON authorization policy document.
+func ParsePolicy(raw []byte) (*Policy, error) {
+	p := &Policy{}
+	if err := json.Unmarshal(raw, p); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON authorization policy: %v", err)
+	}
+	if p.Name == "" {
+		return nil, fmt.Errorf("JSON authorization policy is missing required field \"name\"")
+	}
+	return p, nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2020 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1beta1
+
+import (
+	"fmt"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	ext_authz "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/ext_authz/v2"
+)
+
+// extAuthzHTTPFilterName is the well-known Envoy HTTP filter name for
+// ext_authz, mirroring how authz_model exports the RBAC filter names.
+const extAuthzHTTPFilterName = "envoy.filters.http.ext_authz"
+
+// ExtAuthzConfig is the ext_authz configuration required to evaluate the
+// CUSTOM policies matched for a workload, one cluster per extension
+// provider referenced.
+type ExtAuthzConfig struct {
+	// Providers lists the extension provider names referenced by the
+	// matched CUSTOM policies, in the order they must be consulted.
+	Providers []string
+}
+
+func newExtAuthzConfig(providers []string) *ExtAuthzConfig {
+	return &ExtAuthzConfig{Providers: providers}
+}
+
+// NamedExtAuthzConfig pairs one extension provider's ext_authz filter
+// configuration with its provider name, so Builder can give each provider
+// its own, uniquely named HTTP filter instead of collapsing them.
+type NamedExtAuthzConfig struct {
+	Provider string
+	Config   *ext_authz.ExtAuthz
+}
+
+// ToEnvoyConfigs builds one ext_authz HTTP filter configuration per
+// referenced provider. Each CUSTOM policy's provider must be evaluated
+// against its own authorization service, so providers are never collapsed
+// into a single filter.
+func (c *ExtAuthzConfig) ToEnvoyConfigs() []*NamedExtAuthzConfig {
+	if c == nil || len(c.Providers) == 0 {
+		return nil
+	}
+	configs := make([]*NamedExtAuthzConfig, 0, len(c.Providers))
+	for _, provider := range c.Providers {
+		configs = append(configs, &NamedExtAuthzConfig{
+			Provider: provider,
+			Config: &ext_authz.ExtAuthz{
+				Services: &ext_authz.ExtAuthz_GrpcService{
+					GrpcService: &core.GrpcService{
+						TargetSpecifier: &core.GrpcService_EnvoyGrpc_{
+							EnvoyGrpc: &core.GrpcService_EnvoyGrpc{ClusterName: clusterNameForProvider(provider)},
+						},
+					},
+				},
+			},
+		})
+	}
+	return configs
+}
+
+// clusterNameForProvider builds the Envoy cluster name an extension
+// provider's ext_authz config is routed through, following this repo's
+// <direction>|<port>|<subset>|<host> cluster-naming convention used
+// elsewhere for service clusters.
+//
+// TODO: resolve the provider's real host and port from the mesh config
+// ExtensionProviders definition once that is threaded through to this
+// generator; until then the port segment is a placeholder.
+func clusterNameForProvider(provider string) string {
+	return fmt.Sprintf("outbound|%d||%s", 0, provider)
+}
@@ -0,0 +1,159 @@
+// Copyright 2020 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1beta1
+
+import (
+	"fmt"
+
+	http_config "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/rbac/v2"
+	envoy_rbac "github.com/envoyproxy/go-control-plane/envoy/config/rbac/v2"
+
+	securityv1beta1 "istio.io/api/security/v1beta1"
+	"istio.io/istio/pilot/pkg/model"
+	authz_model "istio.io/istio/pilot/pkg/security/authz/model"
+)
+
+// ActionRBAC pairs the RBAC config generated for a single action (ALLOW,
+// DENY or CUSTOM) with that action, so callers can order and merge the
+// per-action filters correctly.
+type ActionRBAC struct {
+	Action securityv1beta1.AuthorizationPolicy_Action
+	Config *http_config.RBAC
+}
+
+// Generator generates the RBAC HTTP/TCP filter configuration from
+// v1beta1 AuthorizationPolicy. Unlike the v1alpha1 generator, a single
+// Generator can produce configs for more than one action: ALLOW, DENY and
+// CUSTOM policies are kept separate so Builder can order DENY ahead of
+// CUSTOM ahead of ALLOW, as Envoy RBAC semantics require.
+type Generator struct {
+	policies []model.AuthorizationPolicy
+
+	// workloadMeta is set only for ambient-mode workloads built through
+	// NewWorkloadGenerator, which have no ServiceMetadata (no hostname). Its
+	// presence makes ruleToPolicy omit the :authority (hosts/not_hosts)
+	// dimension; it carries no principal, namespace or service-account
+	// attributes for the generator to use.
+	workloadMeta *authz_model.WorkloadMetadata
+}
+
+// NewGenerator creates a Generator for the given matched policies. policies
+// may contain a mix of ALLOW, DENY and CUSTOM actions.
+func NewGenerator(policies []model.AuthorizationPolicy) *Generator {
+	if len(policies) == 0 {
+		return nil
+	}
+	return &Generator{policies: policies}
+}
+
+// NewWorkloadGenerator creates a Generator for an ambient-mode workload that
+// has no ServiceMetadata. workloadMeta marks the workload as ambient, so
+// operation.hosts/not_hosts are not matched; no principal, namespace or
+// service-account attributes are read from it.
+func NewWorkloadGenerator(policies []model.AuthorizationPolicy, workloadMeta *authz_model.WorkloadMetadata) *Generator {
+	if len(policies) == 0 {
+		return nil
+	}
+	return &Generator{policies: policies, workloadMeta: workloadMeta}
+}
+
+// Generate implements policy.Generator. For backward compatibility with
+// callers that only care about the allow/deny-less single-filter case, it
+// returns the merged ALLOW config only; DENY and CUSTOM policies are only
+// available through GenerateRBAC.
+func (g *Generator) Generate(forTCPFilter bool) *http_config.RBAC {
+	for _, rbac := range g.GenerateRBAC(forTCPFilter) {
+		if rbac.Action == securityv1beta1.AuthorizationPolicy_ALLOW {
+			return rbac.Config
+		}
+	}
+	return nil
+}
+
+// GenerateRBAC generates one *http_config.RBAC per action present among the
+// matched policies, each containing only the policies for that action.
+func (g *Generator) GenerateRBAC(forTCPFilter bool) []*ActionRBAC {
+	byAction := map[securityv1beta1.AuthorizationPolicy_Action]*envoy_rbac.RBAC{}
+	// Preserve a stable generation order: DENY, ALLOW, CUSTOM. Builder is
+	// responsible for the evaluation order of the resulting filters.
+	order := []securityv1beta1.AuthorizationPolicy_Action{
+		securityv1beta1.AuthorizationPolicy_DENY,
+		securityv1beta1.AuthorizationPolicy_ALLOW,
+		securityv1beta1.AuthorizationPolicy_CUSTOM,
+	}
+
+	for i, policy := range g.policies {
+		action := policy.Spec.GetAction()
+		rbac, ok := byAction[action]
+		if !ok {
+			rbac = &envoy_rbac.RBAC{Action: toEnvoyAction(action), Policies: map[string]*envoy_rbac.Policy{}}
+			byAction[action] = rbac
+		}
+		for j, rule := range policy.Spec.GetRules() {
+			name := fmt.Sprintf("%s[%s.%d.%d]", action, policy.Name, i, j)
+			rbac.Policies[name] = ruleToPolicy(rule, policy.Namespace, g.workloadMeta)
+		}
+	}
+
+	var out []*ActionRBAC
+	for _, action := range order {
+		rbac, ok := byAction[action]
+		if !ok {
+			continue
+		}
+		config := &http_config.RBAC{Rules: rbac}
+		if action == securityv1beta1.AuthorizationPolicy_CUSTOM {
+			// CUSTOM rules go into ShadowRules: they only stamp dynamic
+			// metadata for ext_authz and must not enforce anything themselves.
+			config = &http_config.RBAC{ShadowRules: rbac}
+		}
+		out = append(out, &ActionRBAC{Action: action, Config: config})
+	}
+	return out
+}
+
+// GenerateExtAuthz generates the ext_authz configuration needed to evaluate
+// the CUSTOM policies among the matched policies, or nil if there are none.
+// Each distinct provider is only evaluated once, even if multiple CUSTOM
+// policies reference it.
+func (g *Generator) GenerateExtAuthz() *ExtAuthzConfig {
+	seen := map[string]bool{}
+	var providers []string
+	for _, policy := range g.policies {
+		if policy.Spec.GetAction() != securityv1beta1.AuthorizationPolicy_CUSTOM {
+			continue
+		}
+		name := policy.Spec.GetProvider().GetName()
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		providers = append(providers, name)
+	}
+	if len(providers) == 0 {
+		return nil
+	}
+	return newExtAuthzConfig(providers)
+}
+
+func toEnvoyAction(action securityv1beta1.AuthorizationPolicy_Action) envoy_rbac.RBAC_Action {
+	if action == securityv1beta1.AuthorizationPolicy_DENY {
+		return envoy_rbac.RBAC_DENY
+	}
+	// CUSTOM policies carry no allow/deny semantics of their own in Envoy RBAC
+	// terms: the actual decision is delegated to ext_authz, and the RBAC
+	// config here only exists to stamp dynamic metadata consumed by it.
+	return envoy_rbac.RBAC_ALLOW
+}
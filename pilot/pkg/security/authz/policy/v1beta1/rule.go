@@ -0,0 +1,318 @@
+// Copyright 2020 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1beta1
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	route "github.com/envoyproxy/go-control-plane/envoy/api/v2/route"
+	envoy_rbac "github.com/envoyproxy/go-control-plane/envoy/config/rbac/v2"
+	matcher "github.com/envoyproxy/go-control-plane/envoy/type/matcher"
+
+	securityv1beta1 "istio.io/api/security/v1beta1"
+	authz_model "istio.io/istio/pilot/pkg/security/authz/model"
+)
+
+// defaultTrustDomain is used to qualify a source.principals entry that names
+// a bare service account ("reviews") rather than a full SPIFFE identity.
+const defaultTrustDomain = "cluster.local"
+
+// ruleToPolicy translates a single v1beta1 Rule into an envoy_rbac.Policy.
+// Within one rule.From/rule.To entry, the Source/Operation dimensions (e.g.
+// principals and namespaces) are ANDed together, matching
+// AuthorizationPolicy semantics; multiple entries, and multiple values
+// within the same dimension, are OR'd. rule.When conditions are ANDed
+// against the rule.To permissions. policyNamespace qualifies bare service
+// account names in source.principals with the namespace of the policy that
+// owns the rule. workloadMeta is non-nil only for ambient workloads built
+// through NewWorkloadGenerator, which have no Service hostname for
+// to.operation.hosts/not_hosts to match against.
+func ruleToPolicy(rule *securityv1beta1.Rule, policyNamespace string, workloadMeta *authz_model.WorkloadMetadata) *envoy_rbac.Policy {
+	return &envoy_rbac.Policy{
+		Principals:  fromToPrincipals(rule.GetFrom(), policyNamespace),
+		Permissions: toAndWhenToPermissions(rule.GetTo(), rule.GetWhen(), workloadMeta),
+	}
+}
+
+func fromToPrincipals(from []*securityv1beta1.Rule_From, policyNamespace string) []*envoy_rbac.Principal {
+	if len(from) == 0 {
+		return []*envoy_rbac.Principal{{Identifier: &envoy_rbac.Principal_Any{Any: true}}}
+	}
+	principals := make([]*envoy_rbac.Principal, 0, len(from))
+	for _, f := range from {
+		principals = append(principals, sourceToPrincipal(f.GetSource(), policyNamespace))
+	}
+	return principals
+}
+
+func sourceToPrincipal(src *securityv1beta1.Source, policyNamespace string) *envoy_rbac.Principal {
+	principalNames := func(v string) *envoy_rbac.Principal { return principalName(qualifyPrincipal(v, policyNamespace)) }
+
+	var dims []*envoy_rbac.Principal
+	if p := orPrincipals(src.GetPrincipals(), principalNames); p != nil {
+		dims = append(dims, p)
+	}
+	if p := orPrincipals(src.GetNamespaces(), principalNamespace); p != nil {
+		dims = append(dims, p)
+	}
+	if p := orPrincipals(src.GetNotPrincipals(), principalNames); p != nil {
+		dims = append(dims, negatePrincipal(p))
+	}
+	if p := orPrincipals(src.GetNotNamespaces(), principalNamespace); p != nil {
+		dims = append(dims, negatePrincipal(p))
+	}
+	return andPrincipals(dims)
+}
+
+// qualifyPrincipal expands a bare service account name into a full SPIFFE
+// identity scoped to policyNamespace, leaving already-qualified identities
+// (and the "*" wildcard) untouched.
+func qualifyPrincipal(v, policyNamespace string) string {
+	if v == "*" || policyNamespace == "" || strings.Contains(v, "/") {
+		return v
+	}
+	return fmt.Sprintf("%s/ns/%s/sa/%s", defaultTrustDomain, policyNamespace, v)
+}
+
+func principalName(v string) *envoy_rbac.Principal {
+	return &envoy_rbac.Principal{
+		Identifier: &envoy_rbac.Principal_Authenticated_{
+			Authenticated: &envoy_rbac.Principal_Authenticated{PrincipalName: stringMatcher(v)},
+		},
+	}
+}
+
+func principalNamespace(ns string) *envoy_rbac.Principal {
+	return &envoy_rbac.Principal{
+		Identifier: &envoy_rbac.Principal_Authenticated_{
+			Authenticated: &envoy_rbac.Principal_Authenticated{
+				PrincipalName: &matcher.StringMatcher{
+					MatchPattern: &matcher.StringMatcher_SafeRegex{
+						SafeRegex: &matcher.RegexMatcher{
+							EngineType: &matcher.RegexMatcher_GoogleRe2{GoogleRe2: &matcher.RegexMatcher_GoogleRE2{}},
+							Regex:      fmt.Sprintf(".*/ns/%s/.*", regexp.QuoteMeta(ns)),
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func orPrincipals(values []string, toPrincipal func(string) *envoy_rbac.Principal) *envoy_rbac.Principal {
+	if len(values) == 0 {
+		return nil
+	}
+	ids := make([]*envoy_rbac.Principal, 0, len(values))
+	for _, v := range values {
+		ids = append(ids, toPrincipal(v))
+	}
+	if len(ids) == 1 {
+		return ids[0]
+	}
+	return &envoy_rbac.Principal{Identifier: &envoy_rbac.Principal_OrIds{OrIds: &envoy_rbac.Principal_Set{Ids: ids}}}
+}
+
+func andPrincipals(ids []*envoy_rbac.Principal) *envoy_rbac.Principal {
+	switch len(ids) {
+	case 0:
+		return &envoy_rbac.Principal{Identifier: &envoy_rbac.Principal_Any{Any: true}}
+	case 1:
+		return ids[0]
+	default:
+		return &envoy_rbac.Principal{Identifier: &envoy_rbac.Principal_AndIds{AndIds: &envoy_rbac.Principal_Set{Ids: ids}}}
+	}
+}
+
+func negatePrincipal(p *envoy_rbac.Principal) *envoy_rbac.Principal {
+	return &envoy_rbac.Principal{Identifier: &envoy_rbac.Principal_NotId{NotId: p}}
+}
+
+// toAndWhenToPermissions translates rule.To and rule.When into the single
+// AND of (the OR of the rule.To entries) and (the AND of the rule.When
+// conditions), returned as a one-element Permissions list unless both are
+// empty, in which case the policy imposes no permission restriction at all.
+func toAndWhenToPermissions(to []*securityv1beta1.Rule_To, when []*securityv1beta1.Condition,
+	workloadMeta *authz_model.WorkloadMetadata) []*envoy_rbac.Permission {
+	opPermission := operationsToPermission(to, workloadMeta)
+	condPermission := conditionsToPermission(when)
+
+	switch {
+	case opPermission == nil && condPermission == nil:
+		return []*envoy_rbac.Permission{{Rule: &envoy_rbac.Permission_Any{Any: true}}}
+	case opPermission == nil:
+		return []*envoy_rbac.Permission{condPermission}
+	case condPermission == nil:
+		return []*envoy_rbac.Permission{opPermission}
+	default:
+		return []*envoy_rbac.Permission{andPermissions([]*envoy_rbac.Permission{opPermission, condPermission})}
+	}
+}
+
+func operationsToPermission(to []*securityv1beta1.Rule_To, workloadMeta *authz_model.WorkloadMetadata) *envoy_rbac.Permission {
+	if len(to) == 0 {
+		return nil
+	}
+	perms := make([]*envoy_rbac.Permission, 0, len(to))
+	for _, t := range to {
+		perms = append(perms, operationToPermission(t.GetOperation(), workloadMeta))
+	}
+	return orPermissions(perms)
+}
+
+func operationToPermission(op *securityv1beta1.Operation, workloadMeta *authz_model.WorkloadMetadata) *envoy_rbac.Permission {
+	var dims []*envoy_rbac.Permission
+
+	// Ambient workloads (workloadMeta != nil) are addressed by pod identity
+	// rather than by a Kubernetes Service, so there is no :authority value
+	// for operation.hosts/not_hosts to match against.
+	if workloadMeta == nil {
+		if p := orPermissions(headerPermissions(":authority", op.GetHosts())); p != nil {
+			dims = append(dims, p)
+		}
+		if p := orPermissions(headerPermissions(":authority", op.GetNotHosts())); p != nil {
+			dims = append(dims, negatePermission(p))
+		}
+	}
+	if p := orPermissions(headerPermissions(":method", op.GetMethods())); p != nil {
+		dims = append(dims, p)
+	}
+	if p := orPermissions(headerPermissions(":method", op.GetNotMethods())); p != nil {
+		dims = append(dims, negatePermission(p))
+	}
+	if p := orPermissions(headerPermissions(":path", op.GetPaths())); p != nil {
+		dims = append(dims, p)
+	}
+	if p := orPermissions(headerPermissions(":path", op.GetNotPaths())); p != nil {
+		dims = append(dims, negatePermission(p))
+	}
+	return andPermissions(dims)
+}
+
+// requestHeaderConditionPrefix is the "when" condition key prefix this
+// generator can translate today; other condition kinds (source.ip,
+// connection.sni, ...) are left unenforced here rather than guessed at.
+const requestHeaderConditionPrefix = "request.headers["
+
+func conditionsToPermission(when []*securityv1beta1.Condition) *envoy_rbac.Permission {
+	var dims []*envoy_rbac.Permission
+	for _, cond := range when {
+		name, ok := requestHeaderName(cond.GetKey())
+		if !ok {
+			continue
+		}
+		if p := orPermissions(headerPermissions(name, cond.GetValues())); p != nil {
+			dims = append(dims, p)
+		}
+		if p := orPermissions(headerPermissions(name, cond.GetNotValues())); p != nil {
+			dims = append(dims, negatePermission(p))
+		}
+	}
+	if len(dims) == 0 {
+		return nil
+	}
+	return andPermissions(dims)
+}
+
+func requestHeaderName(key string) (string, bool) {
+	if !strings.HasPrefix(key, requestHeaderConditionPrefix) || !strings.HasSuffix(key, "]") {
+		return "", false
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(key, requestHeaderConditionPrefix), "]"), true
+}
+
+func headerPermissions(name string, values []string) []*envoy_rbac.Permission {
+	if len(values) == 0 {
+		return nil
+	}
+	perms := make([]*envoy_rbac.Permission, 0, len(values))
+	for _, v := range values {
+		perms = append(perms, &envoy_rbac.Permission{Rule: &envoy_rbac.Permission_Header{Header: headerMatcher(name, v)}})
+	}
+	return perms
+}
+
+func orPermissions(perms []*envoy_rbac.Permission) *envoy_rbac.Permission {
+	switch len(perms) {
+	case 0:
+		return nil
+	case 1:
+		return perms[0]
+	default:
+		return &envoy_rbac.Permission{Rule: &envoy_rbac.Permission_OrRules{OrRules: &envoy_rbac.Permission_Set{Rules: perms}}}
+	}
+}
+
+func andPermissions(perms []*envoy_rbac.Permission) *envoy_rbac.Permission {
+	switch len(perms) {
+	case 0:
+		return &envoy_rbac.Permission{Rule: &envoy_rbac.Permission_Any{Any: true}}
+	case 1:
+		return perms[0]
+	default:
+		return &envoy_rbac.Permission{Rule: &envoy_rbac.Permission_AndRules{AndRules: &envoy_rbac.Permission_Set{Rules: perms}}}
+	}
+}
+
+func negatePermission(p *envoy_rbac.Permission) *envoy_rbac.Permission {
+	return &envoy_rbac.Permission{Rule: &envoy_rbac.Permission_NotRule{NotRule: p}}
+}
+
+// stringMatcher translates a principal string into an Envoy StringMatcher,
+// following the same "*"/"prefix*"/"*suffix"/exact convention as the
+// jsonpolicy generator's matcher.
+func stringMatcher(v string) *matcher.StringMatcher {
+	switch {
+	case v == "*":
+		return &matcher.StringMatcher{
+			MatchPattern: &matcher.StringMatcher_SafeRegex{
+				SafeRegex: &matcher.RegexMatcher{
+					EngineType: &matcher.RegexMatcher_GoogleRe2{GoogleRe2: &matcher.RegexMatcher_GoogleRE2{}},
+					Regex:      ".*",
+				},
+			},
+		}
+	case strings.HasSuffix(v, "*"):
+		return &matcher.StringMatcher{MatchPattern: &matcher.StringMatcher_Prefix{Prefix: strings.TrimSuffix(v, "*")}}
+	case strings.HasPrefix(v, "*"):
+		return &matcher.StringMatcher{MatchPattern: &matcher.StringMatcher_Suffix{Suffix: strings.TrimPrefix(v, "*")}}
+	default:
+		return &matcher.StringMatcher{MatchPattern: &matcher.StringMatcher_Exact{Exact: v}}
+	}
+}
+
+// headerMatcher translates a method/path/header string value into an Envoy
+// HeaderMatcher for the header named name, using the same convention.
+func headerMatcher(name, v string) *route.HeaderMatcher {
+	hm := &route.HeaderMatcher{Name: name}
+	switch {
+	case v == "*":
+		hm.HeaderMatchSpecifier = &route.HeaderMatcher_SafeRegexMatch{
+			SafeRegexMatch: &matcher.RegexMatcher{
+				EngineType: &matcher.RegexMatcher_GoogleRe2{GoogleRe2: &matcher.RegexMatcher_GoogleRE2{}},
+				Regex:      ".*",
+			},
+		}
+	case strings.HasSuffix(v, "*"):
+		hm.HeaderMatchSpecifier = &route.HeaderMatcher_PrefixMatch{PrefixMatch: strings.TrimSuffix(v, "*")}
+	case strings.HasPrefix(v, "*"):
+		hm.HeaderMatchSpecifier = &route.HeaderMatcher_SuffixMatch{SuffixMatch: strings.TrimPrefix(v, "*")}
+	default:
+		hm.HeaderMatchSpecifier = &route.HeaderMatcher_ExactMatch{ExactMatch: v}
+	}
+	return hm
+}
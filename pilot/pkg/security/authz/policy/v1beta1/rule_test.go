@@ -0,0 +1,146 @@
+// Copyright 2020 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1beta1
+
+import (
+	"testing"
+
+	envoy_rbac "github.com/envoyproxy/go-control-plane/envoy/config/rbac/v2"
+
+	securityv1beta1 "istio.io/api/security/v1beta1"
+	authz_model "istio.io/istio/pilot/pkg/security/authz/model"
+)
+
+func TestRuleToPolicy_PrincipalsAreQualifiedAndAnded(t *testing.T) {
+	rule := &securityv1beta1.Rule{
+		From: []*securityv1beta1.Rule_From{{
+			Source: &securityv1beta1.Source{
+				Principals: []string{"reviews", "cluster.local/ns/other/sa/ratings"},
+				Namespaces: []string{"default"},
+			},
+		}},
+	}
+
+	p := ruleToPolicy(rule, "bookinfo", nil)
+
+	if len(p.Principals) != 1 {
+		t.Fatalf("got %d principals, want 1 (single from-entry)", len(p.Principals))
+	}
+	and, ok := p.Principals[0].Identifier.(*envoy_rbac.Principal_AndIds)
+	if !ok {
+		t.Fatalf("principal is %T, want Principal_AndIds combining the principals and namespaces dimensions", p.Principals[0].Identifier)
+	}
+	if len(and.AndIds.Ids) != 2 {
+		t.Fatalf("got %d ANDed dimensions, want 2 (principals, namespaces)", len(and.AndIds.Ids))
+	}
+
+	principalsDim, ok := and.AndIds.Ids[0].Identifier.(*envoy_rbac.Principal_OrIds)
+	if !ok {
+		t.Fatalf("principals dimension is %T, want Principal_OrIds", and.AndIds.Ids[0].Identifier)
+	}
+	bare := principalsDim.OrIds.Ids[0].Identifier.(*envoy_rbac.Principal_Authenticated_).Authenticated.PrincipalName.GetExact()
+	if want := "cluster.local/ns/bookinfo/sa/reviews"; bare != want {
+		t.Errorf("bare service account %q was qualified as %q, want %q", "reviews", bare, want)
+	}
+	qualified := principalsDim.OrIds.Ids[1].Identifier.(*envoy_rbac.Principal_Authenticated_).Authenticated.PrincipalName.GetExact()
+	if want := "cluster.local/ns/other/sa/ratings"; qualified != want {
+		t.Errorf("already-qualified principal was rewritten to %q, want unchanged %q", qualified, want)
+	}
+}
+
+func TestRuleToPolicy_NoFromMatchesAny(t *testing.T) {
+	p := ruleToPolicy(&securityv1beta1.Rule{}, "bookinfo", nil)
+
+	if len(p.Principals) != 1 {
+		t.Fatalf("got %d principals, want 1", len(p.Principals))
+	}
+	if _, ok := p.Principals[0].Identifier.(*envoy_rbac.Principal_Any); !ok {
+		t.Errorf("principal is %T, want Principal_Any when the rule has no from entries", p.Principals[0].Identifier)
+	}
+}
+
+func TestRuleToPolicy_OperationAndWhenAreAnded(t *testing.T) {
+	rule := &securityv1beta1.Rule{
+		To: []*securityv1beta1.Rule_To{{
+			Operation: &securityv1beta1.Operation{
+				Paths: []string{"/books/*", "/reviews/*"},
+			},
+		}},
+		When: []*securityv1beta1.Condition{{
+			Key:    "request.headers[x-env]",
+			Values: []string{"prod", "staging"},
+		}},
+	}
+
+	p := ruleToPolicy(rule, "bookinfo", nil)
+
+	if len(p.Permissions) != 1 {
+		t.Fatalf("got %d permissions, want 1", len(p.Permissions))
+	}
+	and, ok := p.Permissions[0].Rule.(*envoy_rbac.Permission_AndRules)
+	if !ok {
+		t.Fatalf("permission is %T, want Permission_AndRules combining the operation and when conditions", p.Permissions[0].Rule)
+	}
+	if len(and.AndRules.Rules) != 2 {
+		t.Fatalf("got %d ANDed dimensions, want 2 (operation, when)", len(and.AndRules.Rules))
+	}
+}
+
+func TestRequestHeaderName(t *testing.T) {
+	tests := []struct {
+		key    string
+		want   string
+		wantOk bool
+	}{
+		{key: "request.headers[x-env]", want: "x-env", wantOk: true},
+		{key: "source.ip", wantOk: false},
+		{key: "request.headers[x-env", wantOk: false},
+	}
+	for _, tt := range tests {
+		got, ok := requestHeaderName(tt.key)
+		if ok != tt.wantOk || (ok && got != tt.want) {
+			t.Errorf("requestHeaderName(%q) = (%q, %v), want (%q, %v)", tt.key, got, ok, tt.want, tt.wantOk)
+		}
+	}
+}
+
+// TestRuleToPolicy_AmbientWorkloadSkipsHostMatch verifies that ambient
+// workloads, which have no Service hostname, do not get a :authority
+// permission dimension for operation.hosts/not_hosts even when the rule
+// specifies them - unlike sidecar workloads (workloadMeta == nil), which do.
+func TestRuleToPolicy_AmbientWorkloadSkipsHostMatch(t *testing.T) {
+	rule := &securityv1beta1.Rule{
+		To: []*securityv1beta1.Rule_To{{
+			Operation: &securityv1beta1.Operation{
+				Hosts: []string{"reviews.default.svc.cluster.local"},
+				Paths: []string{"/books/*"},
+			},
+		}},
+	}
+
+	sidecar := ruleToPolicy(rule, "bookinfo", nil)
+	sidecarAnd, ok := sidecar.Permissions[0].Rule.(*envoy_rbac.Permission_AndRules)
+	if !ok {
+		t.Fatalf("sidecar permission is %T, want Permission_AndRules combining hosts and paths", sidecar.Permissions[0].Rule)
+	}
+	if len(sidecarAnd.AndRules.Rules) != 2 {
+		t.Fatalf("sidecar: got %d ANDed dimensions, want 2 (hosts, paths)", len(sidecarAnd.AndRules.Rules))
+	}
+
+	ambient := ruleToPolicy(rule, "bookinfo", &authz_model.WorkloadMetadata{})
+	if _, ok := ambient.Permissions[0].Rule.(*envoy_rbac.Permission_Header); !ok {
+		t.Errorf("ambient permission is %T, want the single Permission_Header for paths with no :authority dimension", ambient.Permissions[0].Rule)
+	}
+}